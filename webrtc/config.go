@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Config is the SFU's startup configuration: the ICE servers to offer
+// clients, the public IP(s) to advertise for NAT 1:1 when the server
+// runs behind a NAT, the UDP port range candidates are allocated from,
+// and how often to re-send a PLI to each video publisher.
+type Config struct {
+	ICEServers    []ICEServerConfig `json:"iceServers"`
+	PublicIP      []string          `json:"publicIP,omitempty"`
+	PortMin       uint16            `json:"portMin,omitempty"`
+	PortMax       uint16            `json:"portMax,omitempty"`
+	PLIIntervalMS int               `json:"pliIntervalMS,omitempty"`
+}
+
+type ICEServerConfig struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// loadConfig reads Config as JSON from path. A missing file falls back
+// to a single public STUN server.
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Config{ICEServers: []ICEServerConfig{{URLs: []string{"stun:stun.l.google.com:19302"}}}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// pliInterval is how often to re-request a keyframe once the initial
+// PLI has been sent, defaulting to 2s if unset.
+func (c *Config) pliInterval() time.Duration {
+	if c.PLIIntervalMS <= 0 {
+		return 2 * time.Second
+	}
+	return time.Duration(c.PLIIntervalMS) * time.Millisecond
+}
+
+// iceServers converts the config's ICE servers to the pion type.
+func (c *Config) iceServers() []webrtc.ICEServer {
+	out := make([]webrtc.ICEServer, 0, len(c.ICEServers))
+	for _, s := range c.ICEServers {
+		out = append(out, webrtc.ICEServer{
+			URLs:       s.URLs,
+			Username:   s.Username,
+			Credential: s.Credential,
+		})
+	}
+	return out
+}
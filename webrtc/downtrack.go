@@ -0,0 +1,31 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// downTrack forwards RTP packets from an upTrack straight to one
+// subscriber's peer connection, without decoding or re-encoding.
+type downTrack struct {
+	track *webrtc.TrackLocalStaticRTP
+
+	mu sync.Mutex
+}
+
+func newDownTrack(id, streamID string, codec webrtc.RTPCodecCapability) (*downTrack, error) {
+	t, err := webrtc.NewTrackLocalStaticRTP(codec, id, streamID)
+	if err != nil {
+		return nil, err
+	}
+	return &downTrack{track: t}, nil
+}
+
+// writeRTP relays a single packet to the subscriber.
+func (d *downTrack) writeRTP(pkt *rtp.Packet) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.track.WriteRTP(pkt)
+}
@@ -5,22 +5,26 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/pion/rtcp"
-	"github.com/pion/rtp/codecs"
 	"github.com/pion/webrtc/v3"
-	"github.com/pion/webrtc/v3/pkg/media"
-	"github.com/pion/webrtc/v3/pkg/media/samplebuilder"
 )
 
 type SignalMsg struct {
-	Type      string                   `json:"type"`
-	Offer     *SessionDescWrap         `json:"offer,omitempty"`
-	Answer    *SessionDescWrap         `json:"answer,omitempty"`
-	Candidate *webrtc.ICECandidateInit `json:"candidate,omitempty"`
+	Type        string                   `json:"type"`
+	Room        string                   `json:"room,omitempty"`
+	PeerID      string                   `json:"peerId,omitempty"`
+	Source      string                   `json:"source,omitempty"`
+	Offer       *SessionDescWrap         `json:"offer,omitempty"`
+	Answer      *SessionDescWrap         `json:"answer,omitempty"`
+	Candidate   *webrtc.ICECandidateInit `json:"candidate,omitempty"`
+	PublisherID string                   `json:"publisherId,omitempty"` // select-layer
+	TrackID     string                   `json:"trackId,omitempty"`     // select-layer
+	Rid         string                   `json:"rid,omitempty"`         // select-layer
 }
 
 type SessionDescWrap struct {
@@ -30,13 +34,36 @@ type SessionDescWrap struct {
 
 var up = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
 
+var peerSeq atomic.Uint64
+
+// server bundles the webrtc.API and ICE configuration every connection
+// is served from, built once at startup instead of per connection.
+type server struct {
+	api *webrtc.API
+	cfg *Config
+}
+
 func main() {
-	http.HandleFunc("/ws", wsHandler)
+	configPath := "config.json"
+	if v := os.Getenv("SFU_CONFIG"); v != "" {
+		configPath = v
+	}
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		log.Fatal("loadConfig:", err)
+	}
+	api, err := newAPI(cfg)
+	if err != nil {
+		log.Fatal("newAPI:", err)
+	}
+	srv := &server{api: api, cfg: cfg}
+
+	http.HandleFunc("/ws", srv.wsHandler)
 	log.Println("[HTTP] ws on :8765/ws")
 	log.Fatal(http.ListenAndServe(":8765", nil))
 }
 
-func wsHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) wsHandler(w http.ResponseWriter, r *http.Request) {
 	ws, err := up.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("upgrade:", err)
@@ -45,17 +72,46 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 	defer ws.Close()
 	log.Println("[WS] client connected")
 
-	cfg := webrtc.Configuration{
+	rtcCfg := webrtc.Configuration{
+		ICEServers:         s.cfg.iceServers(),
 		ICETransportPolicy: webrtc.ICETransportPolicyAll, // keep simple while debugging
 	}
 
-	pc, err := webrtc.NewPeerConnection(cfg)
+	pc, err := s.api.NewPeerConnection(rtcCfg)
 	if err != nil {
 		log.Println("NewPeerConnection:", err)
 		return
 	}
 	defer pc.Close()
 
+	// Negotiate both kinds bidirectionally instead of only ever sending
+	// a single VP8 track, so voice works alongside video. Opus audio
+	// goes through the same upTrack/downTrack RTP relay as video (room.go)
+	// rather than a samplebuilder echo: the room forwards packets
+	// byte-for-byte to every subscriber, so there is nothing to decode
+	// to samples and echo back to the publisher.
+	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendrecv,
+	}); err != nil {
+		log.Println("AddTransceiverFromKind video:", err)
+		return
+	}
+	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendrecv,
+	}); err != nil {
+		log.Println("AddTransceiverFromKind audio:", err)
+		return
+	}
+
+	peer := newPeer(fmt.Sprintf("peer-%d", peerSeq.Add(1)), pc, ws)
+	defer func() {
+		if room := peer.getRoom(); room != nil {
+			for _, other := range room.leave(peer) {
+				other.sendPeerLeft(peer.id)
+			}
+		}
+	}()
+
 	// --- GS DataChannel (client-created) ---
 	var gsOpen atomic.Bool
 	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
@@ -90,21 +146,6 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 		log.Println("[RTC] PC:", s.String())
 	})
 
-	// --- Outgoing echo track: send proper VP8 frames as Samples ---
-	echoTrack, err := webrtc.NewTrackLocalStaticSample(
-		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
-		"video", "echo",
-	)
-	if err != nil {
-		log.Println("NewTrackLocalStaticSample:", err)
-		return
-	}
-	_, err = pc.AddTrack(echoTrack)
-	if err != nil {
-		log.Println("AddTrack:", err)
-		return
-	}
-
 	// ICE candidates back to browser
 	pc.OnICECandidate(func(c *webrtc.ICECandidate) {
 		if c == nil {
@@ -113,63 +154,52 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 		_ = ws.WriteJSON(SignalMsg{Type: "candidate", Candidate: ptr(c.ToJSON())})
 	})
 
-	// Handle incoming media
+	// Renegotiate whenever the room subscribes this peer to a new
+	// upTrack (AddTrack after the initial offer/answer triggers this).
+	pc.OnNegotiationNeeded(func() {
+		offer, err := pc.CreateOffer(nil)
+		if err != nil {
+			log.Println("[SFU] CreateOffer:", err)
+			return
+		}
+		if err := pc.SetLocalDescription(offer); err != nil {
+			log.Println("[SFU] SetLocalDescription:", err)
+			return
+		}
+		if err := peer.send(SignalMsg{
+			Type:  "offer",
+			Offer: &SessionDescWrap{Type: "offer", Sdp: offer.SDP},
+		}); err != nil {
+			log.Println("[WS] negotiation offer:", err)
+		}
+	})
+
+	// Handle incoming publisher media: fan it out to every other peer
+	// already in the room via a per-track upTrack.
 	pc.OnTrack(func(tr *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
-		if tr.Kind() != webrtc.RTPCodecTypeVideo {
+		room := peer.getRoom()
+		if room == nil {
+			log.Println("[SFU] track published before join, dropping:", tr.ID())
 			return
 		}
-		log.Println("[RTC] OnTrack video codec:", tr.Codec().MimeType)
+		log.Println("[RTC] OnTrack", tr.Kind().String(), "codec:", tr.Codec().MimeType)
 
-		// Request keyframes aggressively at the start, then periodically
-		go func(ssrc uint32) {
-			for i := 0; i < 8; i++ {
+		if tr.Kind() == webrtc.RTPCodecTypeVideo {
+			// A single PLI on keyframe request, then throttled to the
+			// configured interval instead of an 8-packet back-to-back burst.
+			go func(ssrc uint32) {
 				_ = pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: ssrc}})
-				time.Sleep(150 * time.Millisecond)
-			}
-			t := time.NewTicker(2 * time.Second)
-			defer t.Stop()
-			for range t.C {
-				_ = pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: ssrc}})
-			}
-		}(uint32(tr.SSRC()))
-
-		// ✅ Correct: build frames from full RTP packets
-		depacketizer := &codecs.VP8Packet{}
-		sb := samplebuilder.New(100, depacketizer, tr.Codec().ClockRate)
-
-		last := time.Now()
-		var wrote int64
-
-		for {
-			pkt, _, err := tr.ReadRTP()
-			if err != nil {
-				log.Println("[RTC] Track read ended:", err)
-				return
-			}
-			sb.Push(pkt)
-
-			for {
-				sample := sb.Pop()
-				if sample == nil {
-					break
-				}
-
-				// Estimate duration from wall clock (keeps playback smooth)
-				now := time.Now()
-				dur := now.Sub(last)
-				if dur <= 0 || dur > 200*time.Millisecond {
-					dur = 33 * time.Millisecond
-				}
-				last = now
-
-				if err := echoTrack.WriteSample(media.Sample{Data: sample.Data, Duration: dur}); err == nil {
-					wrote++
-					if wrote%60 == 0 {
-						log.Println("[ECHO] wrote samples:", wrote)
-					}
+				t := time.NewTicker(s.cfg.pliInterval())
+				defer t.Stop()
+				for range t.C {
+					_ = pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: ssrc}})
 				}
-			}
+			}(uint32(tr.SSRC()))
 		}
+
+		u := peer.addUpTrack(tr, room)
+		room.addUpTrack(u)
+		go u.writeLoop()
 	})
 
 	// --- Signaling loop ---
@@ -186,6 +216,41 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		switch msg.Type {
+		case "join":
+			roomID := msg.Room
+			if roomID == "" {
+				roomID = "default"
+			}
+			room := getOrCreateRoom(roomID)
+			peer.setRoom(room)
+
+			others := room.join(peer)
+			for _, other := range others {
+				other.sendPeerJoined(peer.id)
+				peer.sendPeerJoined(other.id)
+			}
+			room.subscribeNewPeer(peer)
+
+		case "leave":
+			room := peer.getRoom()
+			if room == nil {
+				continue
+			}
+			others := room.leave(peer)
+			for _, other := range others {
+				other.sendPeerLeft(peer.id)
+			}
+			peer.setRoom(nil)
+
+		case "select-layer":
+			room := peer.getRoom()
+			if room == nil {
+				continue
+			}
+			if err := room.SelectLayer(peer.id, msg.PublisherID, msg.TrackID, msg.Rid); err != nil {
+				log.Println("[SFU] SelectLayer:", err)
+			}
+
 		case "offer":
 			if msg.Offer == nil {
 				log.Println("[WS] offer missing")
@@ -221,6 +286,28 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 			}
 			log.Println("[RTC] sent answer")
 
+		case "answer":
+			if msg.Answer == nil {
+				log.Println("[WS] answer missing")
+				continue
+			}
+			if err := pc.SetRemoteDescription(webrtc.SessionDescription{
+				Type: webrtc.SDPTypeAnswer,
+				SDP:  msg.Answer.Sdp,
+			}); err != nil {
+				log.Println("SetRemoteDescription:", err)
+			}
+
+		case "play":
+			source, ok := playSources[msg.Source]
+			if !ok {
+				log.Println("[PLAY] unknown source:", msg.Source)
+				continue
+			}
+			if err := PlayFile(pc, source.video, source.audio); err != nil {
+				log.Println("[PLAY] PlayFile:", err)
+			}
+
 		case "candidate":
 			if msg.Candidate == nil {
 				continue
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/nack"
+	"github.com/pion/webrtc/v3"
+)
+
+// codecRegistry lists every codec the SFU is willing to negotiate. This
+// is deliberately not a Depacketizer registry: since the room (chunk0-1)
+// forwards RTP byte-for-byte instead of decoding to samples, there is no
+// per-codec depacketizer to select — registering a MimeType's
+// RTPCodecParameters here is the only change a new publisher codec
+// needs.
+var codecRegistry = []struct {
+	kind webrtc.RTPCodecType
+	p    webrtc.RTPCodecParameters
+}{
+	{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+		PayloadType:        96,
+	}},
+	{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9, ClockRate: 90000, SDPFmtpLine: "profile-id=0"},
+		PayloadType:        98,
+	}},
+	{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f"},
+		PayloadType:        102,
+	}},
+	{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2},
+		PayloadType:        111,
+	}},
+}
+
+// codecForPayloadType looks up the codec capability codecRegistry
+// negotiated for pt, for upTrack.writeLoop to rebind a subscriber's
+// downTracks to when a publisher's payload type names a different codec
+// mid-stream.
+func codecForPayloadType(pt webrtc.PayloadType) (webrtc.RTPCodecCapability, bool) {
+	for _, c := range codecRegistry {
+		if c.p.PayloadType == pt {
+			return c.p.RTPCodecCapability, true
+		}
+	}
+	return webrtc.RTPCodecCapability{}, false
+}
+
+// newMediaEngine registers codecRegistry explicitly instead of relying
+// on RegisterDefaultCodecs, so the set of codecs a publisher can offer
+// is one place a reviewer can read top to bottom.
+func newMediaEngine() (*webrtc.MediaEngine, error) {
+	m := &webrtc.MediaEngine{}
+	for _, c := range codecRegistry {
+		if err := m.RegisterCodec(c.p, c.kind); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// newAPI builds the webrtc.API every connection is served from: the
+// codec registry above, plus a SettingEngine configured from cfg so the
+// server can sit behind a NAT or a public VPS without candidate
+// gathering failing silently.
+func newAPI(cfg *Config) (*webrtc.API, error) {
+	m, err := newMediaEngine()
+	if err != nil {
+		return nil, err
+	}
+
+	se := webrtc.SettingEngine{}
+	if len(cfg.PublicIP) > 0 {
+		se.SetNAT1To1IPs(cfg.PublicIP, webrtc.ICECandidateTypeHost)
+	}
+	if cfg.PortMin != 0 || cfg.PortMax != 0 {
+		if err := se.SetEphemeralUDPPortRange(cfg.PortMin, cfg.PortMax); err != nil {
+			return nil, err
+		}
+	}
+
+	// The TWCC sender generates transport-wide feedback for the media
+	// publishers send us; no bitrate estimate is computed from TWCC.
+	// trackGroup.watchFeedback auto-switches simulcast layers from REMB
+	// alone. REMB packets need no interceptor of their own: they arrive as
+	// plain RTCP on each downTrack's RTPSender, which watchFeedback reads
+	// directly. TWCC feedback sent by subscribers is not read.
+	//
+	// This deliberately builds the interceptor list by hand instead of
+	// calling webrtc.RegisterDefaultInterceptors: its NACK responder
+	// would retransmit from its own per-sender buffer on every NACK,
+	// duplicating what trackGroup.resend already does from packetCache.
+	// We keep the NACK generator (so loss is still reported at all) and
+	// drop only the responder.
+	ir := &interceptor.Registry{}
+	if err := configureNackGenerator(m, ir); err != nil {
+		return nil, err
+	}
+	if err := webrtc.ConfigureRTCPReports(ir); err != nil {
+		return nil, err
+	}
+	if err := webrtc.ConfigureTWCCSender(m, ir); err != nil {
+		return nil, err
+	}
+
+	return webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithSettingEngine(se), webrtc.WithInterceptorRegistry(ir)), nil
+}
+
+// configureNackGenerator mirrors webrtc.ConfigureNack but registers only
+// its GeneratorInterceptor, not the ResponderInterceptor: the responder
+// retransmits lost packets from its own buffer, which would race with
+// (and duplicate) trackGroup.resend's retransmits from packetCache.
+func configureNackGenerator(m *webrtc.MediaEngine, ir *interceptor.Registry) error {
+	generator, err := nack.NewGeneratorInterceptor()
+	if err != nil {
+		return err
+	}
+	m.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack"}, webrtc.RTPCodecTypeVideo)
+	m.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack", Parameter: "pli"}, webrtc.RTPCodecTypeVideo)
+	ir.Add(generator)
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/pion/rtp"
+)
+
+// packetCache keeps the most recent RTP packets of an upTrack so a
+// subscriber's dropped sequence number can be resent without asking the
+// publisher to resend it.
+type packetCache struct {
+	mu      sync.Mutex
+	packets []*rtp.Packet
+}
+
+func newPacketCache(size int) *packetCache {
+	return &packetCache{packets: make([]*rtp.Packet, size)}
+}
+
+// store records pkt, evicting whatever previously occupied its slot.
+func (c *packetCache) store(pkt *rtp.Packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.packets[int(pkt.SequenceNumber)%len(c.packets)] = pkt
+}
+
+// get returns the cached packet for seq, or nil if it was evicted or
+// never seen.
+func (c *packetCache) get(seq uint16) *rtp.Packet {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.packets[int(seq)%len(c.packets)]
+	if p != nil && p.SequenceNumber == seq {
+		return p
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+)
+
+// Peer is one signaling client's WebRTC session: a peer connection plus
+// the upTracks it publishes into its Room and the websocket used to
+// signal it.
+type Peer struct {
+	id string
+	pc *webrtc.PeerConnection
+
+	ws   *websocket.Conn
+	wsMu sync.Mutex
+
+	mu       sync.Mutex // guards room and upTracks
+	room     *Room
+	upTracks map[string]*upTrack // keyed by "<track id>/<rid>"
+}
+
+func newPeer(id string, pc *webrtc.PeerConnection, ws *websocket.Conn) *Peer {
+	return &Peer{
+		id:       id,
+		pc:       pc,
+		ws:       ws,
+		upTracks: map[string]*upTrack{},
+	}
+}
+
+func (p *Peer) send(msg SignalMsg) error {
+	p.wsMu.Lock()
+	defer p.wsMu.Unlock()
+	return p.ws.WriteJSON(msg)
+}
+
+func (p *Peer) sendPeerJoined(peerID string) {
+	if err := p.send(SignalMsg{Type: "peer-joined", PeerID: peerID}); err != nil {
+		log.Println("[WS] peer-joined:", err)
+	}
+}
+
+func (p *Peer) sendPeerLeft(peerID string) {
+	if err := p.send(SignalMsg{Type: "peer-left", PeerID: peerID}); err != nil {
+		log.Println("[WS] peer-left:", err)
+	}
+}
+
+// getRoom returns the room p has joined, or nil if it hasn't joined one.
+func (p *Peer) getRoom() *Room {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.room
+}
+
+// setRoom records the room p has joined, or nil once it leaves.
+func (p *Peer) setRoom(r *Room) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.room = r
+}
+
+// addUpTrack wraps tr in an upTrack bound to room and records it as
+// published by p. It neither adds the upTrack to room nor starts its
+// writeLoop; the caller does both.
+func (p *Peer) addUpTrack(tr *webrtc.TrackRemote, room *Room) *upTrack {
+	u := newUpTrack(p, tr, room)
+
+	p.mu.Lock()
+	p.upTracks[tr.ID()+"/"+tr.RID()] = u
+	p.mu.Unlock()
+
+	return u
+}
+
+// stopUpTracks signals every upTrack p has ever published to stop
+// forwarding, e.g. once p has left the room but kept its PeerConnection
+// (and therefore its publisher tracks) open.
+func (p *Peer) stopUpTracks() {
+	p.mu.Lock()
+	tracks := make([]*upTrack, 0, len(p.upTracks))
+	for _, u := range p.upTracks {
+		tracks = append(tracks, u)
+	}
+	p.mu.Unlock()
+
+	for _, u := range tracks {
+		u.stop()
+	}
+}
+
+// subscribe adds a downTrack sourced from u to p's peer connection, so p
+// starts receiving u's publisher's media. The subscriber's peer
+// connection renegotiates via OnNegotiationNeeded once AddTrack returns.
+// The returned RTPSender carries the subscriber's REMB/TWCC feedback.
+func (p *Peer) subscribe(u *upTrack) (*downTrack, *webrtc.RTPSender) {
+	d, err := newDownTrack(u.track.ID(), u.peer.id, u.track.Codec().RTPCodecCapability)
+	if err != nil {
+		log.Println("[SFU] newDownTrack:", err)
+		return nil, nil
+	}
+	sender, err := p.pc.AddTrack(d.track)
+	if err != nil {
+		log.Println("[SFU] AddTrack:", err)
+		return nil, nil
+	}
+	u.addLocal(d)
+	return d, sender
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/ivfreader"
+	"github.com/pion/webrtc/v3/pkg/media/oggreader"
+)
+
+// playSources maps the "source" a client requests in a {"type":"play"}
+// message to the IVF/Ogg files on disk that back it.
+var playSources = map[string]struct {
+	video string
+	audio string
+}{
+	"movie": {video: "media/movie.ivf", audio: "media/movie.ogg"},
+}
+
+// PlayFile streams a pre-encoded IVF video and/or Ogg Opus audio file
+// into pc as new tracks. Video frames are sent on a fixed ticker at the
+// frame duration given by the IVF header's timebase; Ogg pages are sent
+// every 20ms, each sample carrying the duration of its page. This
+// gives automated tests a deterministic media source instead of a live
+// camera echo, and lets the same signaling stack serve on-demand content.
+func PlayFile(pc *webrtc.PeerConnection, videoPath, audioPath string) error {
+	if videoPath != "" {
+		if err := playIVF(pc, videoPath); err != nil {
+			return err
+		}
+	}
+	if audioPath != "" {
+		if err := playOgg(pc, audioPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func playIVF(pc *webrtc.PeerConnection, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	reader, header, err := ivfreader.NewWith(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	track, err := webrtc.NewTrackLocalStaticSample(
+		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+		"video", "file-"+path,
+	)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := pc.AddTrack(track); err != nil {
+		f.Close()
+		return err
+	}
+
+	frameDuration := time.Duration(float64(header.TimebaseNumerator)/float64(header.TimebaseDenominator)*1000) * time.Millisecond
+
+	go func() {
+		defer f.Close()
+		ticker := time.NewTicker(frameDuration)
+		defer ticker.Stop()
+		for range ticker.C {
+			frame, _, err := reader.ParseNextFrame()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				log.Println("[PLAY] ivf read:", err)
+				return
+			}
+			if err := track.WriteSample(media.Sample{Data: frame, Duration: frameDuration}); err != nil {
+				log.Println("[PLAY] ivf write sample:", err)
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func playOgg(pc *webrtc.PeerConnection, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	reader, _, err := oggreader.NewWith(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	track, err := webrtc.NewTrackLocalStaticSample(
+		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2},
+		"audio", "file-"+path,
+	)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := pc.AddTrack(track); err != nil {
+		f.Close()
+		return err
+	}
+
+	const oggPageDuration = 20 * time.Millisecond
+
+	go func() {
+		defer f.Close()
+		ticker := time.NewTicker(oggPageDuration)
+		defer ticker.Stop()
+		var lastGranule uint64
+		for range ticker.C {
+			pageData, pageHeader, err := reader.ParseNextPage()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				log.Println("[PLAY] ogg read:", err)
+				return
+			}
+			sampleCount := pageHeader.GranulePosition - lastGranule
+			lastGranule = pageHeader.GranulePosition
+			sampleDuration := time.Duration(sampleCount) * time.Second / 48000
+			if err := track.WriteSample(media.Sample{Data: pageData, Duration: sampleDuration}); err != nil {
+				log.Println("[PLAY] ogg write sample:", err)
+				return
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Room fans media out between every Peer that has joined it: it owns the
+// set of trackGroups contributed by its peers (one per published track,
+// holding every simulcast layer of it), and subscribes each newly
+// published track to every other peer already in the room.
+type Room struct {
+	id string
+
+	mu     sync.Mutex
+	peers  map[string]*Peer
+	groups map[string]*trackGroup // keyed by "<publisher peer id>/<track id>"
+}
+
+var (
+	roomsMu sync.Mutex
+	rooms   = map[string]*Room{}
+)
+
+// getOrCreateRoom returns the Room for id, creating it on first use.
+func getOrCreateRoom(id string) *Room {
+	roomsMu.Lock()
+	defer roomsMu.Unlock()
+
+	r, ok := rooms[id]
+	if !ok {
+		r = &Room{
+			id:     id,
+			peers:  map[string]*Peer{},
+			groups: map[string]*trackGroup{},
+		}
+		rooms[id] = r
+	}
+	return r
+}
+
+// join adds p to the room and returns the peers that were already in it.
+func (r *Room) join(p *Peer) []*Peer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	others := r.otherPeersLocked(p.id)
+	r.peers[p.id] = p
+	return others
+}
+
+// subscribeNewPeer wires p up to every track already published by the
+// rest of the room, starting each on its primary (highest quality) layer.
+func (r *Room) subscribeNewPeer(p *Peer) {
+	r.mu.Lock()
+	groups := make([]*trackGroup, 0, len(r.groups))
+	for _, g := range r.groups {
+		if g.owner.id != p.id {
+			groups = append(groups, g)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, g := range groups {
+		if u := g.primary(); u != nil {
+			g.subscribe(p, u)
+		}
+	}
+}
+
+// leave removes p from the room: its own published groups stop
+// forwarding to every subscriber and are dropped, and it is unsubscribed
+// from everyone else's.
+func (r *Room) leave(p *Peer) []*Peer {
+	r.mu.Lock()
+	owned := make([]*trackGroup, 0)
+	subscribedTo := make([]*trackGroup, 0)
+	for key, g := range r.groups {
+		if g.owner.id == p.id {
+			delete(r.groups, key)
+			owned = append(owned, g)
+		} else {
+			subscribedTo = append(subscribedTo, g)
+		}
+	}
+	remaining := r.otherPeersLocked(p.id)
+	delete(r.peers, p.id)
+	empty := len(r.peers) == 0
+	r.mu.Unlock()
+
+	for _, g := range owned {
+		g.teardown()
+	}
+	for _, g := range subscribedTo {
+		g.removeSub(p.id)
+	}
+	// Stop p's own upTrack.writeLoop()s: otherwise they keep reading and
+	// forwarding RTP from p's still-open PeerConnection even though p is
+	// no longer in any room.
+	p.stopUpTracks()
+
+	if empty {
+		roomsMu.Lock()
+		if len(r.peers) == 0 {
+			delete(rooms, r.id)
+		}
+		roomsMu.Unlock()
+	}
+	return remaining
+}
+
+// addUpTrack registers u as one layer of its publisher's track. The
+// first layer seen for a track subscribes every other peer in the room
+// to it; later layers (simulcast's mid/low RIDs) just become available
+// for SelectLayer to switch a subscriber onto.
+func (r *Room) addUpTrack(u *upTrack) {
+	key := u.peer.id + "/" + u.track.ID()
+
+	r.mu.Lock()
+	g, ok := r.groups[key]
+	if !ok {
+		g = newTrackGroup(u.peer, u.track.ID())
+		r.groups[key] = g
+	}
+	others := r.otherPeersLocked(u.peer.id)
+	r.mu.Unlock()
+
+	if g.addLayer(u) {
+		for _, other := range others {
+			g.subscribe(other, u)
+		}
+	}
+}
+
+// removeUpTrack drops u once its publisher stops sending that layer.
+func (r *Room) removeUpTrack(u *upTrack) {
+	key := u.peer.id + "/" + u.track.ID()
+
+	r.mu.Lock()
+	g, ok := r.groups[key]
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if g.removeLayer(u) {
+		r.mu.Lock()
+		delete(r.groups, key)
+		r.mu.Unlock()
+	}
+}
+
+// switchLayerCodec recreates every subscriber downTrack currently
+// sourced from u against codec, for when u's publisher's codec genuinely
+// changes mid-stream. See upTrack.writeLoop.
+func (r *Room) switchLayerCodec(u *upTrack, codec webrtc.RTPCodecCapability) {
+	key := u.peer.id + "/" + u.track.ID()
+
+	r.mu.Lock()
+	g, ok := r.groups[key]
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+	g.switchCodec(u, codec)
+}
+
+// SelectLayer switches subscriberID's view of publisherID's trackID to
+// the simulcast layer rid, if the publisher currently sends it.
+func (r *Room) SelectLayer(subscriberID, publisherID, trackID, rid string) error {
+	r.mu.Lock()
+	g, ok := r.groups[publisherID+"/"+trackID]
+	r.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("room %s: no track %s/%s", r.id, publisherID, trackID)
+	}
+	return g.selectLayer(subscriberID, rid)
+}
+
+// otherPeersLocked must be called with r.mu held.
+func (r *Room) otherPeersLocked(excludeID string) []*Peer {
+	out := make([]*Peer, 0, len(r.peers))
+	for id, p := range r.peers {
+		if id != excludeID {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// fakeRemoteTrack is a minimal remoteTrack for exercising upTrack/Room
+// bookkeeping without a live PeerConnection.
+type fakeRemoteTrack struct {
+	id  string
+	rid string
+}
+
+func (f *fakeRemoteTrack) ID() string  { return f.id }
+func (f *fakeRemoteTrack) RID() string { return f.rid }
+func (f *fakeRemoteTrack) Codec() webrtc.RTPCodecParameters {
+	return webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+	}
+}
+func (f *fakeRemoteTrack) ReadRTP() (*rtp.Packet, interceptor.Attributes, error) {
+	return nil, nil, io.EOF
+}
+
+func newTestPeer(id string) *Peer {
+	return newPeer(id, nil, nil)
+}
+
+func TestRoomJoinLeave(t *testing.T) {
+	r := &Room{id: "t", peers: map[string]*Peer{}, groups: map[string]*trackGroup{}}
+	a := newTestPeer("a")
+	b := newTestPeer("b")
+
+	if others := r.join(a); len(others) != 0 {
+		t.Fatalf("join(a): expected no existing peers, got %d", len(others))
+	}
+	others := r.join(b)
+	if len(others) != 1 || others[0].id != "a" {
+		t.Fatalf("join(b): expected [a], got %v", others)
+	}
+
+	remaining := r.leave(a)
+	if len(remaining) != 1 || remaining[0].id != "b" {
+		t.Fatalf("leave(a): expected [b] remaining, got %v", remaining)
+	}
+	if _, ok := r.peers["a"]; ok {
+		t.Fatal("leave(a): a should no longer be in r.peers")
+	}
+
+	r.leave(b)
+	if len(r.peers) != 0 {
+		t.Fatalf("leave(b): expected empty room, got %d peers", len(r.peers))
+	}
+}
+
+func TestRoomAddRemoveUpTrack(t *testing.T) {
+	r := &Room{id: "t", peers: map[string]*Peer{}, groups: map[string]*trackGroup{}}
+	owner := newTestPeer("owner")
+	owner.room = r
+	r.join(owner)
+
+	u := newUpTrack(owner, &fakeRemoteTrack{id: "v1"}, r)
+	r.addUpTrack(u)
+
+	key := owner.id + "/" + u.track.ID()
+	if _, ok := r.groups[key]; !ok {
+		t.Fatal("addUpTrack: expected a group to be created")
+	}
+
+	r.removeUpTrack(u)
+	if _, ok := r.groups[key]; ok {
+		t.Fatal("removeUpTrack: expected the group to be dropped once its last layer is removed")
+	}
+}
+
+func TestRoomLeaveTearsDownOwnedGroups(t *testing.T) {
+	r := &Room{id: "t", peers: map[string]*Peer{}, groups: map[string]*trackGroup{}}
+	owner := newTestPeer("owner")
+	owner.room = r
+	r.join(owner)
+
+	u := newUpTrack(owner, &fakeRemoteTrack{id: "v1"}, r)
+	r.addUpTrack(u)
+
+	// Wire up a subscriber's downTrack by hand, bypassing Peer.subscribe
+	// (which needs a live PeerConnection), so teardown can be observed.
+	down, err := newDownTrack("v1", "owner", webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000})
+	if err != nil {
+		t.Fatalf("newDownTrack: %v", err)
+	}
+	u.addLocal(down)
+
+	key := owner.id + "/" + u.track.ID()
+	g := r.groups[key]
+	g.subs["sub"] = &layerSub{peer: newTestPeer("sub"), down: down, rid: u.rid}
+
+	r.leave(owner)
+
+	if len(u.local) != 0 {
+		t.Fatalf("leave: expected owned upTrack to have no subscribers left, got %d", len(u.local))
+	}
+	if _, ok := r.groups[key]; ok {
+		t.Fatal("leave: expected the owner's group to be dropped")
+	}
+}
+
+func TestTrackGroupSelectLayer(t *testing.T) {
+	owner := newTestPeer("owner")
+	g := newTrackGroup(owner, "v1")
+
+	high := newUpTrack(owner, &fakeRemoteTrack{id: "v1", rid: "high"}, nil)
+	low := newUpTrack(owner, &fakeRemoteTrack{id: "v1", rid: "low"}, nil)
+	g.addLayer(low)
+	g.addLayer(high)
+
+	down, err := newDownTrack("v1", "owner", webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000})
+	if err != nil {
+		t.Fatalf("newDownTrack: %v", err)
+	}
+	low.addLocal(down)
+	g.subs["sub"] = &layerSub{peer: newTestPeer("sub"), down: down, rid: "low"}
+
+	if err := g.selectLayer("sub", "high"); err != nil {
+		t.Fatalf("selectLayer: %v", err)
+	}
+	if len(low.local) != 0 {
+		t.Fatalf("selectLayer: expected low to have dropped its subscriber, got %d", len(low.local))
+	}
+	if len(high.local) != 1 {
+		t.Fatalf("selectLayer: expected high to have gained the subscriber, got %d", len(high.local))
+	}
+	if g.subs["sub"].rid != "high" {
+		t.Fatalf("selectLayer: expected subscription rid to be updated, got %q", g.subs["sub"].rid)
+	}
+
+	if err := g.selectLayer("sub", "missing"); err == nil {
+		t.Fatal("selectLayer: expected an error for an unknown layer")
+	}
+	if err := g.selectLayer("ghost", "high"); err == nil {
+		t.Fatal("selectLayer: expected an error for an unknown subscriber")
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// trackGroup is every simulcast layer (or, for a non-simulcast
+// publisher, the single layer) a publisher sends for one logical track,
+// plus the downTrack each subscriber currently receives it through.
+type trackGroup struct {
+	owner   *Peer
+	trackID string
+
+	mu    sync.Mutex
+	byRID map[string]*upTrack
+	subs  map[string]*layerSub // subscriber peer id -> subscription
+}
+
+type layerSub struct {
+	peer   *Peer
+	down   *downTrack
+	sender *webrtc.RTPSender
+	rid    string
+}
+
+func newTrackGroup(owner *Peer, trackID string) *trackGroup {
+	return &trackGroup{
+		owner:   owner,
+		trackID: trackID,
+		byRID:   map[string]*upTrack{},
+		subs:    map[string]*layerSub{},
+	}
+}
+
+// addLayer registers u under its RID and reports whether it is the
+// first layer seen for this track, i.e. whether subscribers still need
+// to be wired up to it.
+func (g *trackGroup) addLayer(u *upTrack) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	first := len(g.byRID) == 0
+	g.byRID[u.rid] = u
+	return first
+}
+
+// removeLayer drops u and reports whether the group is now empty.
+func (g *trackGroup) removeLayer(u *upTrack) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.byRID, u.rid)
+	return len(g.byRID) == 0
+}
+
+// primary returns the layer a new subscriber starts on: the highest
+// quality RID the publisher currently sends, or the only layer there is
+// for a non-simulcast track.
+func (g *trackGroup) primary() *upTrack {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, rid := range []string{"high", "mid", "low", ""} {
+		if u, ok := g.byRID[rid]; ok {
+			return u
+		}
+	}
+	for _, u := range g.byRID {
+		return u
+	}
+	return nil
+}
+
+// subscribe adds a downTrack for `to` sourced from u and starts watching
+// the subscriber's REMB/NACK feedback so the layer can be auto-switched
+// and lost packets resent from the source upTrack's cache. The
+// subscriber's TWCC feedback is not read.
+func (g *trackGroup) subscribe(to *Peer, u *upTrack) {
+	d, sender := to.subscribe(u)
+	if d == nil {
+		return
+	}
+
+	g.mu.Lock()
+	g.subs[to.id] = &layerSub{peer: to, down: d, sender: sender, rid: u.rid}
+	g.mu.Unlock()
+
+	if sender != nil {
+		go g.watchFeedback(to.id, sender)
+	}
+}
+
+// removeSub unsubscribes a departing peer's downTrack from whichever
+// upTrack layer currently feeds it, and removes the track from its own
+// peer connection, before dropping the bookkeeping entry.
+func (g *trackGroup) removeSub(subscriberID string) {
+	g.mu.Lock()
+	sub, ok := g.subs[subscriberID]
+	if ok {
+		delete(g.subs, subscriberID)
+	}
+	var up *upTrack
+	if ok {
+		up = g.byRID[sub.rid]
+	}
+	g.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	if up != nil {
+		up.delLocal(sub.down)
+	}
+	if sub.sender != nil {
+		if err := sub.peer.pc.RemoveTrack(sub.sender); err != nil {
+			log.Println("[SFU] RemoveTrack:", err)
+		}
+	}
+}
+
+// teardown stops forwarding this group's owner's media to every
+// subscriber, for when the owner leaves the room. Unlike removeSub this
+// walks every layer the group still holds, since the owner (not a
+// subscriber) is the one going away.
+func (g *trackGroup) teardown() {
+	g.mu.Lock()
+	subs := make([]*layerSub, 0, len(g.subs))
+	for _, sub := range g.subs {
+		subs = append(subs, sub)
+	}
+	for id := range g.subs {
+		delete(g.subs, id)
+	}
+	layers := make([]*upTrack, 0, len(g.byRID))
+	for _, u := range g.byRID {
+		layers = append(layers, u)
+	}
+	g.mu.Unlock()
+
+	for _, sub := range subs {
+		for _, u := range layers {
+			u.delLocal(sub.down)
+		}
+		if sub.sender != nil {
+			if err := sub.peer.pc.RemoveTrack(sub.sender); err != nil {
+				log.Println("[SFU] RemoveTrack:", err)
+			}
+		}
+	}
+}
+
+// selectLayer switches subscriberID's downTrack to rid, if the
+// publisher currently has that layer.
+func (g *trackGroup) selectLayer(subscriberID, rid string) error {
+	g.mu.Lock()
+	newUp, haveLayer := g.byRID[rid]
+	sub, haveSub := g.subs[subscriberID]
+	var oldUp *upTrack
+	if haveSub {
+		oldUp = g.byRID[sub.rid]
+	}
+	g.mu.Unlock()
+
+	if !haveLayer {
+		return fmt.Errorf("trackGroup %s: no %q layer", g.trackID, rid)
+	}
+	if !haveSub {
+		return fmt.Errorf("trackGroup %s: subscriber %s not found", g.trackID, subscriberID)
+	}
+	if sub.rid == rid {
+		return nil
+	}
+
+	if oldUp != nil {
+		oldUp.delLocal(sub.down)
+	}
+	newUp.addLocal(sub.down)
+
+	g.mu.Lock()
+	sub.rid = rid
+	g.mu.Unlock()
+	return nil
+}
+
+// switchCodec recreates every downTrack currently sourced from u with a
+// new codec capability, for a publisher whose codec genuinely changes
+// mid-stream: TrackLocalStaticRTP binds its SDP codec once at creation,
+// so the existing downTracks would otherwise keep claiming the stale
+// MimeType forever.
+func (g *trackGroup) switchCodec(u *upTrack, codec webrtc.RTPCodecCapability) {
+	g.mu.Lock()
+	affected := make([]*layerSub, 0)
+	for _, sub := range g.subs {
+		if sub.rid == u.rid {
+			affected = append(affected, sub)
+		}
+	}
+	g.mu.Unlock()
+
+	for _, sub := range affected {
+		newDown, err := newDownTrack(u.track.ID(), u.peer.id, codec)
+		if err != nil {
+			log.Println("[SFU] switchCodec newDownTrack:", err)
+			continue
+		}
+		if sub.sender == nil {
+			continue
+		}
+		if err := sub.sender.ReplaceTrack(newDown.track); err != nil {
+			log.Println("[SFU] switchCodec ReplaceTrack:", err)
+			continue
+		}
+
+		oldDown := sub.down
+		u.delLocal(oldDown)
+		u.addLocal(newDown)
+
+		g.mu.Lock()
+		sub.down = newDown
+		g.mu.Unlock()
+	}
+}
+
+// watchFeedback reads RTCP the subscriber sends back on sender: REMB
+// auto-switches the forwarded layer, and NACK resends the lost sequence
+// numbers from the current layer's packetCache.
+func (g *trackGroup) watchFeedback(subscriberID string, sender *webrtc.RTPSender) {
+	for {
+		pkts, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		for _, pkt := range pkts {
+			switch p := pkt.(type) {
+			case *rtcp.ReceiverEstimatedMaximumBitrate:
+				if err := g.selectLayer(subscriberID, rateToRID(p.Bitrate)); err != nil {
+					log.Println("[SFU] auto layer switch:", err)
+				}
+			case *rtcp.TransportLayerNack:
+				g.resend(subscriberID, p)
+			}
+		}
+	}
+}
+
+// resend looks up every sequence number nack'd in p in the subscriber's
+// current layer's packetCache and writes back whatever is still cached.
+func (g *trackGroup) resend(subscriberID string, nack *rtcp.TransportLayerNack) {
+	g.mu.Lock()
+	sub, ok := g.subs[subscriberID]
+	var up *upTrack
+	if ok {
+		up = g.byRID[sub.rid]
+	}
+	g.mu.Unlock()
+	if !ok || up == nil {
+		return
+	}
+
+	for _, pair := range nack.Nacks {
+		for _, seq := range pair.PacketList() {
+			pkt := up.cache.get(seq)
+			if pkt == nil {
+				continue
+			}
+			if err := sub.down.writeRTP(pkt); err != nil {
+				log.Println("[SFU] NACK resend:", err)
+			}
+		}
+	}
+}
+
+// rateToRID maps a REMB bitrate estimate to the simulcast RID a
+// publisher would reasonably label it, matching common encoder presets.
+func rateToRID(bitrate float32) string {
+	switch {
+	case bitrate >= 1_200_000:
+		return "high"
+	case bitrate >= 400_000:
+		return "mid"
+	default:
+		return "low"
+	}
+}
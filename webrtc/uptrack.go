@@ -0,0 +1,129 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// remoteTrack is the slice of *webrtc.TrackRemote that upTrack needs.
+// Narrowing it to an interface lets tests exercise Room/trackGroup
+// bookkeeping with a fake track instead of a live PeerConnection.
+type remoteTrack interface {
+	ID() string
+	RID() string
+	Codec() webrtc.RTPCodecParameters
+	ReadRTP() (*rtp.Packet, interceptor.Attributes, error)
+}
+
+// upTrack wraps one publisher's remote track. Its writer goroutine reads
+// each RTP packet exactly once and fans it out to every subscribed
+// downTrack, caching it along the way so a subscriber can ask for a
+// retransmit instead of the publisher re-sending.
+type upTrack struct {
+	peer  *Peer
+	room  *Room // captured at creation, so writeLoop never reads peer.room
+	track remoteTrack
+	rid   string // simulcast layer name ("high"/"mid"/"low"), "" if none
+	cache *packetCache
+
+	done     chan struct{}
+	stopOnce sync.Once
+
+	mu    sync.Mutex
+	local []*downTrack
+}
+
+func newUpTrack(peer *Peer, track remoteTrack, room *Room) *upTrack {
+	return &upTrack{
+		peer:  peer,
+		room:  room,
+		track: track,
+		rid:   track.RID(),
+		cache: newPacketCache(256),
+		done:  make(chan struct{}),
+	}
+}
+
+// stop signals writeLoop to exit without waiting for the underlying
+// track to end, e.g. once peer has left the room but kept its
+// PeerConnection (and this track) open.
+func (u *upTrack) stop() {
+	u.stopOnce.Do(func() { close(u.done) })
+}
+
+// addLocal subscribes d to this upTrack's RTP stream.
+func (u *upTrack) addLocal(d *downTrack) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.local = append(u.local, d)
+}
+
+// delLocal unsubscribes d, e.g. once its peer has left the room.
+func (u *upTrack) delLocal(d *downTrack) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for i, l := range u.local {
+		if l == d {
+			u.local = append(u.local[:i], u.local[i+1:]...)
+			return
+		}
+	}
+}
+
+// notifyLocal forwards pkt to every currently subscribed downTrack.
+func (u *upTrack) notifyLocal(pkt *rtp.Packet) {
+	u.mu.Lock()
+	locals := make([]*downTrack, len(u.local))
+	copy(locals, u.local)
+	u.mu.Unlock()
+
+	for _, d := range locals {
+		if err := d.writeRTP(pkt); err != nil {
+			log.Println("[SFU] downTrack write:", err)
+		}
+	}
+}
+
+// writeLoop reads RTP from the publisher until the track ends or stop is
+// called, fanning each packet out to subscribers and caching it for
+// retransmission.
+//
+// Packets are relayed byte-for-byte: a payload type renumbering of the
+// same codec needs nothing from us. When the new payload type names a
+// different codec entirely (e.g. a pion client falling back from H264 to
+// VP8), every subscriber's downTrack was created once at subscribe time
+// bound to the old codec (peer.go's subscribe), so we recreate them
+// against the new one via switchLayerCodec instead of relaying packets a
+// decoder bound to the old codec can't parse.
+func (u *upTrack) writeLoop() {
+	defer u.room.removeUpTrack(u)
+
+	lastPT := u.track.Codec().PayloadType
+	for {
+		select {
+		case <-u.done:
+			log.Println("[SFU] upTrack stopped:", u.track.ID())
+			return
+		default:
+		}
+
+		pkt, _, err := u.track.ReadRTP()
+		if err != nil {
+			log.Println("[SFU] upTrack read ended:", err)
+			return
+		}
+		if pt := webrtc.PayloadType(pkt.PayloadType); pt != lastPT {
+			log.Printf("[SFU] upTrack %s payload type changed %d -> %d", u.track.ID(), lastPT, pt)
+			lastPT = pt
+			if codec, ok := codecForPayloadType(pt); ok {
+				u.room.switchLayerCodec(u, codec)
+			}
+		}
+		u.cache.store(pkt)
+		u.notifyLocal(pkt)
+	}
+}